@@ -0,0 +1,61 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package archiver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+type filestoreArchiver struct {
+	baseDir string
+}
+
+// NewFilestoreArchiver returns an Archiver that writes each execution's history to a JSON file
+// under baseDir, laid out as <baseDir>/<domainID>/<workflowID>/<runID>.history. It is intended
+// for single-node or development deployments; production deployments should prefer an
+// Archiver backed by a durable blob store (S3, GCS).
+func NewFilestoreArchiver(baseDir string) Archiver {
+	return &filestoreArchiver{baseDir: baseDir}
+}
+
+func (a *filestoreArchiver) Archive(ctx context.Context, request *ArchiveHistoryRequest) error {
+	dir := filepath.Join(a.baseDir, request.DomainID, *request.Execution.WorkflowId)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("archiver: failed to create archive directory: %v", err)
+	}
+
+	data, err := json.Marshal(request.History)
+	if err != nil {
+		return fmt.Errorf("archiver: failed to serialize history: %v", err)
+	}
+
+	path := filepath.Join(dir, *request.Execution.RunId+".history")
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("archiver: failed to write archive file: %v", err)
+	}
+
+	return nil
+}
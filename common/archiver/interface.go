@@ -0,0 +1,56 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package archiver defines the pluggable integration point that lets the archival queue
+// processor stream a finished workflow's history somewhere durable before the execution row is
+// deleted from the primary store. Only history is archived; visibility records are not.
+package archiver
+
+import (
+	"context"
+
+	workflow "github.com/uber/cadence/.gen/go/shared"
+)
+
+type (
+	// ArchiveHistoryRequest carries everything an Archiver needs to durably persist the
+	// history of a single workflow execution.
+	ArchiveHistoryRequest struct {
+		DomainID   string
+		DomainName string
+		Execution  workflow.WorkflowExecution
+		// History holds the execution's history event batches in ascending event order. The
+		// events are not serialized; each Archiver chooses its own encoding.
+		History []*workflow.History
+		// URI identifies where this domain's archives live, e.g. "file:///var/cadence/archival"
+		// or "s3://my-bucket/cadence". Schemes are resolved to a concrete Archiver by the
+		// bootstrap code that wires up the archival queue processor.
+		URI string
+	}
+
+	// Archiver is the pluggable integration point for moving a completed workflow's history
+	// out of the primary store. Implementations are looked up by the scheme of the domain's
+	// configured archival URI (e.g. "file", "s3", "gs") and must be safe for concurrent use.
+	Archiver interface {
+		// Archive durably persists the given history. It must be idempotent: the archival
+		// queue processor may call it more than once for the same execution after a retry.
+		Archive(ctx context.Context, request *ArchiveHistoryRequest) error
+	}
+)
@@ -0,0 +1,78 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package persistence
+
+import "time"
+
+// TransferTaskTypeArchiveExecution is appended to the existing transfer task type enum. It is
+// enqueued when a workflow execution completes so that the archival queue processor can stream
+// its history out to the configured Archiver before the execution is deleted.
+const TransferTaskTypeArchiveExecution = TransferTaskTypeCloseExecution + 100
+
+type (
+	// ArchivalTask identifies a single workflow execution whose history is eligible for
+	// archival; its visibility record is not archived. It is enqueued on the same transfer task
+	// list as the other transfer tasks so it participates in the same per-shard ordering and
+	// ack-level bookkeeping.
+	ArchivalTask struct {
+		VisibilityTimestamp time.Time
+		TaskID               int64
+		DomainID             string
+		WorkflowID           string
+		RunID                string
+		Version              int64
+	}
+)
+
+// GetType returns the type of the archival transfer task.
+func (a *ArchivalTask) GetType() int {
+	return TransferTaskTypeArchiveExecution
+}
+
+// GetVersion returns the version of the archival transfer task.
+func (a *ArchivalTask) GetVersion() int64 {
+	return a.Version
+}
+
+// SetVersion sets the version of the archival transfer task.
+func (a *ArchivalTask) SetVersion(version int64) {
+	a.Version = version
+}
+
+// GetTaskID returns the sequence ID of the archival transfer task.
+func (a *ArchivalTask) GetTaskID() int64 {
+	return a.TaskID
+}
+
+// SetTaskID sets the sequence ID of the archival transfer task.
+func (a *ArchivalTask) SetTaskID(id int64) {
+	a.TaskID = id
+}
+
+// GetVisibilityTimestamp returns the visibility timestamp of the archival transfer task.
+func (a *ArchivalTask) GetVisibilityTimestamp() time.Time {
+	return a.VisibilityTimestamp
+}
+
+// SetVisibilityTimestamp sets the visibility timestamp of the archival transfer task.
+func (a *ArchivalTask) SetVisibilityTimestamp(timestamp time.Time) {
+	a.VisibilityTimestamp = timestamp
+}
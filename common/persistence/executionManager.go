@@ -0,0 +1,52 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package persistence
+
+import (
+	"github.com/uber-common/bark"
+	"github.com/uber/cadence/common/backoff"
+	"github.com/uber/cadence/common/metrics"
+)
+
+// ExecutionManagerPolicy bundles the retry, rate-limit, and metrics settings that
+// NewExecutionManager applies uniformly to every ExecutionManager handed out to shard contexts.
+// Without a single composed entry point, callers could wrap with some of the three decorators
+// and forget others, so each shard would enforce a different policy by accident.
+type ExecutionManagerPolicy struct {
+	RetryPolicy backoff.RetryPolicy
+	IsTransient backoff.IsRetryable
+	Quotas      ExecutionPersistenceQuotas
+	Scope       metrics.Client
+	Logger      bark.Logger
+}
+
+// NewExecutionManager wraps inner with the rate-limited, retryable, and metrics decorators in
+// the order calls actually flow: rate-limiting first, so an over-quota caller is rejected before
+// paying for a retry loop; then retry, so transient failures are retried; metrics innermost, so
+// latency and error counts reflect only the calls that actually reached inner. Because metrics
+// sits inside the retry loop, every attempt is counted, including failed attempts that a later
+// retry recovers from.
+func NewExecutionManager(inner ExecutionManager, policy ExecutionManagerPolicy) ExecutionManager {
+	wrapped := NewExecutionPersistenceMetricsClient(inner, policy.Scope, policy.Logger)
+	wrapped = NewExecutionPersistenceRetryableClient(wrapped, policy.RetryPolicy, policy.IsTransient)
+	wrapped = NewExecutionPersistenceRateLimitedClient(wrapped, policy.Quotas)
+	return wrapped
+}
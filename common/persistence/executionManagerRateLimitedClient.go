@@ -0,0 +1,78 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package persistence
+
+import (
+	"golang.org/x/time/rate"
+)
+
+// ExecutionPersistenceQuotas supplies the per-operation request quota used by the rate-limited
+// execution manager client. A single quota is shared across all callers of that client
+// instance, e.g. per-shard or per-host depending on how it is wired up at factory time.
+type ExecutionPersistenceQuotas struct {
+	GetWorkflowExecutionRPS    int
+	UpdateWorkflowExecutionRPS int
+	DeleteWorkflowExecutionRPS int
+}
+
+// executionManagerRateLimitedClient embeds the full ExecutionManager interface so every method
+// it doesn't explicitly throttle is promoted straight through to inner unchanged.
+type executionManagerRateLimitedClient struct {
+	ExecutionManager
+	getLimiter    *rate.Limiter
+	updateLimiter *rate.Limiter
+	deleteLimiter *rate.Limiter
+}
+
+// NewExecutionPersistenceRateLimitedClient wraps the given ExecutionManager so that each
+// operation is throttled to the corresponding quota. Requests over quota return
+// ErrPersistenceLimitExceeded at once rather than blocking until the quota allows them.
+func NewExecutionPersistenceRateLimitedClient(inner ExecutionManager,
+	quotas ExecutionPersistenceQuotas) ExecutionManager {
+	return &executionManagerRateLimitedClient{
+		ExecutionManager: inner,
+		getLimiter:       rate.NewLimiter(rate.Limit(quotas.GetWorkflowExecutionRPS), quotas.GetWorkflowExecutionRPS),
+		updateLimiter:    rate.NewLimiter(rate.Limit(quotas.UpdateWorkflowExecutionRPS), quotas.UpdateWorkflowExecutionRPS),
+		deleteLimiter:    rate.NewLimiter(rate.Limit(quotas.DeleteWorkflowExecutionRPS), quotas.DeleteWorkflowExecutionRPS),
+	}
+}
+
+func (c *executionManagerRateLimitedClient) GetWorkflowExecution(
+	request *GetWorkflowExecutionRequest) (*GetWorkflowExecutionResponse, error) {
+	if !c.getLimiter.Allow() {
+		return nil, ErrPersistenceLimitExceeded
+	}
+	return c.ExecutionManager.GetWorkflowExecution(request)
+}
+
+func (c *executionManagerRateLimitedClient) UpdateWorkflowExecution(request *UpdateWorkflowExecutionRequest) error {
+	if !c.updateLimiter.Allow() {
+		return ErrPersistenceLimitExceeded
+	}
+	return c.ExecutionManager.UpdateWorkflowExecution(request)
+}
+
+func (c *executionManagerRateLimitedClient) DeleteWorkflowExecution(request *DeleteWorkflowExecutionRequest) error {
+	if !c.deleteLimiter.Allow() {
+		return ErrPersistenceLimitExceeded
+	}
+	return c.ExecutionManager.DeleteWorkflowExecution(request)
+}
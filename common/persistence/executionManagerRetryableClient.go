@@ -0,0 +1,79 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package persistence
+
+import "github.com/uber/cadence/common/backoff"
+
+// executionManagerRetryableClient embeds the full ExecutionManager interface so every method it
+// doesn't explicitly override (CreateWorkflowExecution, ResetMutableState, GetTransferTasks,
+// GetCurrentExecution, ...) is promoted straight through to inner unchanged. This keeps the
+// decorator satisfying ExecutionManager without having to hand-wrap every method just to pass
+// calls along untouched.
+type executionManagerRetryableClient struct {
+	ExecutionManager
+	policy      backoff.RetryPolicy
+	isTransient backoff.IsRetryable
+}
+
+// NewExecutionPersistenceRetryableClient wraps the given ExecutionManager so that every
+// GetWorkflowExecution, UpdateWorkflowExecution and DeleteWorkflowExecution call is retried
+// according to policy until it succeeds or isTransient reports the error as non-retryable.
+// Other methods are passed through without retries. Callers no longer need to remember to call backoff.Retry with the right
+// classifier around each persistence call site.
+func NewExecutionPersistenceRetryableClient(inner ExecutionManager, policy backoff.RetryPolicy,
+	isTransient backoff.IsRetryable) ExecutionManager {
+	return &executionManagerRetryableClient{
+		ExecutionManager: inner,
+		policy:           policy,
+		isTransient:      isTransient,
+	}
+}
+
+func (c *executionManagerRetryableClient) GetWorkflowExecution(
+	request *GetWorkflowExecutionRequest) (*GetWorkflowExecutionResponse, error) {
+	var response *GetWorkflowExecutionResponse
+	op := func() error {
+		var err error
+		response, err = c.ExecutionManager.GetWorkflowExecution(request)
+		return err
+	}
+
+	if err := backoff.Retry(op, c.policy, c.isTransient); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+func (c *executionManagerRetryableClient) UpdateWorkflowExecution(request *UpdateWorkflowExecutionRequest) error {
+	op := func() error {
+		return c.ExecutionManager.UpdateWorkflowExecution(request)
+	}
+
+	return backoff.Retry(op, c.policy, c.isTransient)
+}
+
+func (c *executionManagerRetryableClient) DeleteWorkflowExecution(request *DeleteWorkflowExecutionRequest) error {
+	op := func() error {
+		return c.ExecutionManager.DeleteWorkflowExecution(request)
+	}
+
+	return backoff.Retry(op, c.policy, c.isTransient)
+}
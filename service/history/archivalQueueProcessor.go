@@ -0,0 +1,264 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package history
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/uber-common/bark"
+	workflow "github.com/uber/cadence/.gen/go/shared"
+	"github.com/uber/cadence/common"
+	"github.com/uber/cadence/common/archiver"
+	"github.com/uber/cadence/common/backoff"
+	"github.com/uber/cadence/common/logging"
+	"github.com/uber/cadence/common/metrics"
+	"github.com/uber/cadence/common/persistence"
+)
+
+const (
+	// archivalHistoryPageSize is the page size used when paging through
+	// persistence.HistoryManager to read a completed execution's full history for archival.
+	archivalHistoryPageSize = 1000
+
+	// archivalMaxEventID is passed as NextEventID to mean "read through the end of history";
+	// the archival queue processor always wants the full history, not a bounded range.
+	archivalMaxEventID = math.MaxInt64
+)
+
+// errArchivalUnavailable is returned when a task can't be archived because the domain's
+// archival configuration changed between enqueue and drain time. The task is left un-acked so
+// it is retried rather than silently dropping the execution's history.
+var errArchivalUnavailable = errors.New("archival queue processor: no archiver available for domain")
+
+// archivalQueueProcessor drains ArchivalTasks off the shard's transfer task list, streams the
+// corresponding execution's history out to the domain's configured Archiver, and only once
+// that upload succeeds deletes the history and execution rows. It runs alongside, and is
+// modeled on, the existing transfer/timer queue processors for this shard.
+type archivalQueueProcessor struct {
+	shard            ShardContext
+	executionManager persistence.ExecutionManager
+	historyManager   persistence.HistoryManager
+	archiverProvider archiverProvider
+	retryPolicy      backoff.RetryPolicy
+	logger           bark.Logger
+	metricsClient    metrics.Client
+
+	shutdownCh chan struct{}
+	shutdownWG sync.WaitGroup
+	isStarted  int32
+	isStopped  int32
+}
+
+// archiverProvider resolves the Archiver to use for a given domain, keyed off the domain's
+// configured archival URI scheme (e.g. "file", "s3", "gs").
+type archiverProvider func(domainName string) archiver.Archiver
+
+func newArchivalQueueProcessor(shard ShardContext, executionManager persistence.ExecutionManager,
+	historyManager persistence.HistoryManager, archiverProvider archiverProvider, logger bark.Logger,
+	metricsClient metrics.Client) *archivalQueueProcessor {
+	return &archivalQueueProcessor{
+		shard:            shard,
+		executionManager: executionManager,
+		historyManager:   historyManager,
+		archiverProvider: archiverProvider,
+		retryPolicy:      common.CreatePersistanceRetryPolicy(),
+		logger:           logger.WithField(logging.TagWorkflowComponent, "archival-queue-processor"),
+		metricsClient:    metricsClient,
+		shutdownCh:       make(chan struct{}),
+	}
+}
+
+func (p *archivalQueueProcessor) Start() {
+	if !atomic.CompareAndSwapInt32(&p.isStarted, 0, 1) {
+		return
+	}
+
+	workerCount := p.shard.GetConfig().ArchivalProcessorSchedulerWorkerCount()
+	for i := 0; i < workerCount; i++ {
+		p.shutdownWG.Add(1)
+		go p.processLoop()
+	}
+	p.logger.Info("Archival queue processor started.")
+}
+
+func (p *archivalQueueProcessor) Stop() {
+	if !atomic.CompareAndSwapInt32(&p.isStopped, 0, 1) {
+		return
+	}
+	close(p.shutdownCh)
+	p.shutdownWG.Wait()
+	p.logger.Info("Archival queue processor stopped.")
+}
+
+func (p *archivalQueueProcessor) processLoop() {
+	defer p.shutdownWG.Done()
+
+	pollInterval := time.Second
+	for {
+		select {
+		case <-p.shutdownCh:
+			return
+		case <-time.After(pollInterval):
+			p.processBatch()
+		}
+	}
+}
+
+func (p *archivalQueueProcessor) processBatch() {
+	maxPollRPS := p.shard.GetConfig().ArchivalProcessorMaxPollRPS()
+	tasks, err := p.shard.GetTransferTasks(persistence.TransferTaskTypeArchiveExecution, maxPollRPS)
+	if err != nil {
+		p.logger.Errorf("Archival queue processor failed to read transfer tasks: %v", err)
+		return
+	}
+
+	for _, task := range tasks {
+		if err := p.archiveOne(task); err != nil {
+			p.metricsClient.IncCounter(metrics.ArchivalQueueProcessorScope, metrics.ArchivalTaskFailures)
+			p.logger.Errorf("Archival queue processor failed to archive task %v: %v", task.TaskID, err)
+			continue
+		}
+		p.metricsClient.IncCounter(metrics.ArchivalQueueProcessorScope, metrics.ArchivalTaskSuccess)
+	}
+}
+
+func (p *archivalQueueProcessor) archiveOne(task *persistence.ArchivalTask) error {
+	sw := p.metricsClient.StartTimer(metrics.ArchivalQueueProcessorScope, metrics.ArchivalTaskLatency)
+	defer sw.Stop()
+
+	domainEntry, err := p.shard.GetDomainCache().GetDomainByID(task.DomainID)
+	if err != nil {
+		return err
+	}
+
+	maxRetryLimit := p.shard.GetConfig().ArchivalProcessorMaxRetryLimit()
+	op := func() error {
+		return p.uploadAndDelete(domainEntry.GetInfo().Name, task)
+	}
+
+	return backoff.Retry(op, backoff.NewExponentialRetryPolicy(time.Second).
+		WithMaximumAttempts(maxRetryLimit), common.IsPersistenceTransientError)
+}
+
+// checkArchivalAvailable guards uploadAndDelete against deleting an execution's history when
+// there is nowhere to archive it to. It returns errArchivalUnavailable when archival was enabled
+// at enqueue time but has since been disabled or left unconfigured for the domain -- the task
+// should fail and retry rather than silently losing history with no archive and no error.
+func checkArchivalAvailable(archivalEnabled bool) error {
+	if !archivalEnabled {
+		return errArchivalUnavailable
+	}
+	return nil
+}
+
+func (p *archivalQueueProcessor) uploadAndDelete(domainName string, task *persistence.ArchivalTask) error {
+	if err := checkArchivalAvailable(p.shard.GetConfig().ArchivalHistoryEnabled(domainName)); err != nil {
+		return err
+	}
+
+	history, err := p.readFullHistory(task)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	execution := archivalTaskExecution(task)
+
+	if err := p.archiverProvider(domainName).Archive(ctx, &archiver.ArchiveHistoryRequest{
+		DomainID:   task.DomainID,
+		DomainName: domainName,
+		Execution:  execution,
+		History:    history,
+	}); err != nil {
+		return err
+	}
+
+	// Only delete the history and the execution row once the upload above has actually
+	// succeeded -- deleting either one first would leak the other if this task were to fail
+	// partway through.
+	if err := p.historyManager.DeleteWorkflowExecutionHistory(&persistence.DeleteWorkflowExecutionHistoryRequest{
+		DomainID:  task.DomainID,
+		Execution: execution,
+	}); err != nil {
+		return err
+	}
+
+	return p.executionManager.DeleteWorkflowExecution(&persistence.DeleteWorkflowExecutionRequest{
+		DomainID:   task.DomainID,
+		WorkflowID: task.WorkflowID,
+		RunID:      task.RunID,
+	})
+}
+
+// archivalTaskExecution builds the WorkflowExecution identifying task's execution, for use in
+// both the Archiver request and the history-delete request -- the two must agree on which
+// execution they name, so they are built from this single helper rather than two separate
+// literals that could drift apart.
+func archivalTaskExecution(task *persistence.ArchivalTask) workflow.WorkflowExecution {
+	return workflow.WorkflowExecution{
+		WorkflowId: common.StringPtr(task.WorkflowID),
+		RunId:      common.StringPtr(task.RunID),
+	}
+}
+
+// readFullHistory pages through every history event for the execution named by task, oldest
+// first, until persistence reports no further pages.
+func (p *archivalQueueProcessor) readFullHistory(task *persistence.ArchivalTask) ([]*workflow.History, error) {
+	var events []*workflow.HistoryEvent
+	var nextPageToken []byte
+
+	for {
+		response, err := p.historyManager.GetWorkflowExecutionHistory(&persistence.GetWorkflowExecutionHistoryRequest{
+			DomainID: task.DomainID,
+			Execution: workflow.WorkflowExecution{
+				WorkflowId: common.StringPtr(task.WorkflowID),
+				RunId:      common.StringPtr(task.RunID),
+			},
+			FirstEventID:  common.FirstEventID,
+			NextEventID:   archivalMaxEventID,
+			PageSize:      archivalHistoryPageSize,
+			NextPageToken: nextPageToken,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, response.Events...)
+
+		if len(response.NextPageToken) == 0 {
+			break
+		}
+		nextPageToken = response.NextPageToken
+	}
+
+	if len(events) == 0 {
+		return nil, nil
+	}
+
+	return []*workflow.History{{Events: events}}, nil
+}
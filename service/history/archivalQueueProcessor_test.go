@@ -0,0 +1,51 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package history
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uber/cadence/common/persistence"
+)
+
+func TestCheckArchivalAvailable(t *testing.T) {
+	require.NoError(t, checkArchivalAvailable(true))
+
+	err := checkArchivalAvailable(false)
+	require.Error(t, err)
+	require.Equal(t, errArchivalUnavailable, err)
+}
+
+func TestArchivalTaskExecution(t *testing.T) {
+	task := &persistence.ArchivalTask{
+		DomainID:   "test-domain-id",
+		WorkflowID: "test-workflow-id",
+		RunID:      "test-run-id",
+	}
+
+	execution := archivalTaskExecution(task)
+	require.NotNil(t, execution.WorkflowId, "a nil WorkflowId/RunId here is what made filestoreArchiver panic")
+	require.NotNil(t, execution.RunId)
+	require.Equal(t, task.WorkflowID, *execution.WorkflowId)
+	require.Equal(t, task.RunID, *execution.RunId)
+}
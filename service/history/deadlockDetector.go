@@ -0,0 +1,134 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package history
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/uber-common/bark"
+	"github.com/uber/cadence/common/metrics"
+)
+
+// defaultDeadlockDetectionInterval is used when the configured threshold is non-positive (e.g.
+// an unset or misconfigured dynamic config value), so the detector still runs instead of
+// panicking on time.NewTicker.
+const defaultDeadlockDetectionInterval = 15 * time.Second
+
+// DeadlockDetector periodically pings every cached workflowExecutionContext (and any other
+// Pingable registered with the shard) and reports any that have held their lock for at least
+// threshold, so operators get an alert instead of a silent stall.
+type DeadlockDetector struct {
+	pingables     func() []Pingable
+	threshold     time.Duration
+	logger        bark.Logger
+	metricsClient metrics.Client
+
+	shutdownCh chan struct{}
+	isStarted  int32
+	isStopped  int32
+}
+
+// newDeadlockDetector creates a DeadlockDetector. pingables is called on every tick so the set
+// of cached contexts it checks stays current as the shard's context cache evolves. The shard
+// controller is expected to construct this with a closure over its workflowExecutionContext
+// cache (e.g. `func() []Pingable { return shardCache.ListPingables() }`) and call Start/Stop
+// alongside the rest of the shard's lifecycle; this package only owns the detection loop itself.
+func newDeadlockDetector(pingables func() []Pingable, threshold time.Duration, logger bark.Logger,
+	metricsClient metrics.Client) *DeadlockDetector {
+	return &DeadlockDetector{
+		pingables:     pingables,
+		threshold:     threshold,
+		logger:        logger,
+		metricsClient: metricsClient,
+		shutdownCh:    make(chan struct{}),
+	}
+}
+
+// Start begins the periodic ping loop in a background goroutine.
+func (d *DeadlockDetector) Start() {
+	if !atomic.CompareAndSwapInt32(&d.isStarted, 0, 1) {
+		return
+	}
+	go d.detectLoop()
+}
+
+// Stop terminates the ping loop.
+func (d *DeadlockDetector) Stop() {
+	if !atomic.CompareAndSwapInt32(&d.isStopped, 0, 1) {
+		return
+	}
+	close(d.shutdownCh)
+}
+
+func (d *DeadlockDetector) detectLoop() {
+	// Ping twice per threshold so a single missed tick doesn't delay detection by a full
+	// threshold window. Guard against a misconfigured (zero or negative) threshold, which would
+	// otherwise make NewTicker panic.
+	interval := d.threshold / 2
+	if interval <= 0 {
+		interval = defaultDeadlockDetectionInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.shutdownCh:
+			return
+		case <-ticker.C:
+			d.runChecks()
+		}
+	}
+}
+
+func (d *DeadlockDetector) runChecks() {
+	for _, p := range d.pingables() {
+		for _, check := range p.GetPingChecks() {
+			d.runCheck(check)
+		}
+	}
+}
+
+func (d *DeadlockDetector) runCheck(check PingCheck) {
+	resultCh := make(chan error, 1)
+	go func() {
+		resultCh <- check.Ping()
+	}()
+
+	select {
+	case err := <-resultCh:
+		if err != nil {
+			d.reportStuck(check.Name, err)
+		}
+	case <-time.After(check.Timeout):
+		d.reportStuck(check.Name, fmt.Errorf("ping timed out after %v", check.Timeout))
+	}
+}
+
+func (d *DeadlockDetector) reportStuck(name string, err error) {
+	d.logger.Errorf("Deadlock detector: %v appears stuck: %v", name, err)
+	d.metricsClient.IncCounter(metrics.DeadlockDetectorScope, metrics.DeadlockDetectorStuckCount)
+	// NOTE: corrective action (unloading the shard, fail-fasting the stuck goroutine) is
+	// intentionally left to the shard controller, which is best placed to decide whether an
+	// individual stuck context or the whole shard should be recovered.
+}
@@ -0,0 +1,56 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package history
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckLockHolderStuck(t *testing.T) {
+	now := time.Now()
+	threshold := 30 * time.Second
+
+	require.NoError(t, checkLockHolderStuck(nil, threshold, now), "no holder means the lock is free")
+
+	fresh := &lockHolderInfo{acquireTime: now.Add(-time.Second), stackTrace: "goroutine 1"}
+	require.NoError(t, checkLockHolderStuck(fresh, threshold, now), "a freshly acquired lock must not be reported as stuck")
+
+	stale := &lockHolderInfo{acquireTime: now.Add(-time.Minute), stackTrace: "goroutine 1"}
+	err := checkLockHolderStuck(stale, threshold, now)
+	require.Error(t, err, "a lock held well past threshold must be reported as stuck")
+	require.Contains(t, err.Error(), "goroutine 1")
+
+	atThreshold := &lockHolderInfo{acquireTime: now.Add(-threshold), stackTrace: "goroutine 1"}
+	require.Error(t, checkLockHolderStuck(atThreshold, threshold, now), "held-for-exactly-threshold should already count as stuck")
+}
+
+func TestDeadlockDetectorDetectLoop_NonPositiveThresholdDoesNotPanic(t *testing.T) {
+	for _, threshold := range []time.Duration{0, -time.Second} {
+		d := newDeadlockDetector(func() []Pingable { return nil }, threshold, nil, nil)
+		// Stopping first makes detectLoop return right after it has built its ticker.
+		d.Stop()
+
+		require.NotPanics(t, d.detectLoop)
+	}
+}
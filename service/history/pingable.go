@@ -0,0 +1,48 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package history
+
+import "time"
+
+type (
+	// Pingable is implemented by components that hold locks or other blocking resources that
+	// could silently wedge a goroutine. The deadlock detector periodically calls GetPingChecks
+	// and runs each returned PingCheck with its own timeout, so a stuck component is reported
+	// instead of stalling forever with no visibility.
+	Pingable interface {
+		GetPingChecks() []PingCheck
+	}
+
+	// PingCheck is one liveness probe contributed by a Pingable. Ping must not block
+	// indefinitely on the resource it is checking; if it can't determine liveness quickly it
+	// should use its own lightweight state (e.g. a holder timestamp) rather than trying to
+	// acquire the resource itself.
+	PingCheck struct {
+		// Name identifies the check in logs and metrics, e.g. the workflow execution it guards.
+		Name string
+		// Timeout is how long the deadlock detector waits for Ping to return before treating
+		// the check itself as stuck.
+		Timeout time.Duration
+		// Ping returns a non-nil error, describing the stuck holder, if the resource has been
+		// held longer than is considered healthy.
+		Ping func() error
+	}
+)
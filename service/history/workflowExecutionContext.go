@@ -21,22 +21,71 @@
 package history
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"runtime"
+	"sync"
 	"time"
 
 	"github.com/uber-common/bark"
 	h "github.com/uber/cadence/.gen/go/history"
 	workflow "github.com/uber/cadence/.gen/go/shared"
 	"github.com/uber/cadence/common"
-	"github.com/uber/cadence/common/backoff"
 	"github.com/uber/cadence/common/logging"
 	"github.com/uber/cadence/common/persistence"
 )
 
 const (
 	secondsInDay = int32(24 * time.Hour / time.Second)
+
+	// vectorClockConsistencyRetryInterval is how long loadWithConsistency sleeps between
+	// re-reads while waiting for the predicate to be satisfied.
+	vectorClockConsistencyRetryInterval = 10 * time.Millisecond
 )
 
+// ErrLoadWithConsistencyTimeout is returned by loadWithConsistency when the predicate is still
+// not satisfied once the configured consistency deadline elapses.
+var ErrLoadWithConsistencyTimeout = errors.New("timed out waiting for consistent mutable state")
+
+// VectorClock identifies a point in a shard's monotonic write history. The shard bumps its
+// clock by one on every mutable-state write; comparing a caller-observed clock against the
+// shard's current clock tells loadWithConsistency whether a cached read could possibly be stale.
+// ShardContext.GetCurrentVectorClock and Config.VectorClockConsistencyTimeout, both called
+// below, are the shard-side half of this contract; this file only consumes them.
+type VectorClock struct {
+	ShardID int
+	Clock   int64
+}
+
+// dbRecordVersionCASMode controls how the DBRecordVersion-based CAS token is applied
+// alongside the legacy NextEventID-based Condition during the rolling upgrade.
+const (
+	// dbRecordVersionCASModeOff means only the legacy Condition is sent (pre-upgrade behavior).
+	dbRecordVersionCASModeOff = "off"
+	// dbRecordVersionCASModeDual sends both Condition and DBRecordVersion so the store can
+	// validate against either, allowing hosts at different versions to interoperate.
+	dbRecordVersionCASModeDual = "dual"
+	// dbRecordVersionCASModeV2 sends only DBRecordVersion once the rolling upgrade is complete.
+	dbRecordVersionCASModeV2 = "v2"
+)
+
+// dbRecordVersionCASFields decides which of the legacy Condition and the new DBRecordVersion
+// CAS tokens should be sent for a persistence write, based on mode. It is shared by every write
+// path (updateHelper, resetWorkflowExecution, ...) so they all gate rollout consistently instead
+// of each re-implementing the mode switch.
+func dbRecordVersionCASFields(mode string, condition, nextVersion int64) (casCondition int64, hasCondition bool,
+	casVersion int64, hasVersion bool) {
+	switch mode {
+	case dbRecordVersionCASModeV2:
+		return 0, false, nextVersion, true
+	case dbRecordVersionCASModeDual:
+		return condition, true, nextVersion, true
+	default:
+		return condition, true, 0, false
+	}
+}
+
 type (
 	workflowExecutionContext struct {
 		domainID          string
@@ -48,12 +97,20 @@ type (
 		locker          common.Mutex
 		msBuilder       *mutableStateBuilder
 		updateCondition int64
+		dbRecordVersion int64
 		deleteTimerTask persistence.Task
+		lastVectorClock VectorClock
+
+		lockHolderMu sync.Mutex
+		lockHolder   *lockHolderInfo
 	}
-)
 
-var (
-	persistenceOperationRetryPolicy = common.CreatePersistanceRetryPolicy()
+	// lockHolderInfo records who is currently holding a workflowExecutionContext's lock, so the
+	// deadlock detector can report something actionable when a ping times out.
+	lockHolderInfo struct {
+		acquireTime time.Time
+		stackTrace  string
+	}
 )
 
 func newWorkflowExecutionContext(domainID string, execution workflow.WorkflowExecution, shard ShardContext,
@@ -81,7 +138,7 @@ func (c *workflowExecutionContext) loadWorkflowExecution() (*mutableStateBuilder
 		return c.msBuilder, nil
 	}
 
-	response, err := c.getWorkflowExecutionWithRetry(&persistence.GetWorkflowExecutionRequest{
+	response, err := c.executionManager.GetWorkflowExecution(&persistence.GetWorkflowExecutionRequest{
 		DomainID:  c.domainID,
 		Execution: c.workflowExecution,
 	})
@@ -98,19 +155,75 @@ func (c *workflowExecutionContext) loadWorkflowExecution() (*mutableStateBuilder
 		msBuilder.Load(state)
 		info := state.ExecutionInfo
 		c.updateCondition = info.NextEventID
+		c.dbRecordVersion = info.DBRecordVersion
 	}
 
 	c.msBuilder = msBuilder
+	c.lastVectorClock = c.shard.GetCurrentVectorClock()
 	if err := c.updateVersion(); err != nil {
 		return nil, err
 	}
 	return msBuilder, nil
 }
 
+// loadWithConsistency returns mutable state that is at least as fresh as reqClock and satisfies
+// predicate. Cross-shard callers (signal-with-start, child-workflow completion, replication
+// callbacks) often know a lower bound on the shard's state from a prior write; passing that
+// bound in as reqClock avoids a class of "read your writes" bugs without pessimistically
+// re-reading from persistence on every call. If the cached state is already known to reflect at
+// least reqClock, it is returned directly; otherwise this forces a re-read and retries until
+// predicate is satisfied or ctx's deadline (or the configured consistency timeout) elapses.
+func (c *workflowExecutionContext) loadWithConsistency(ctx context.Context, reqClock VectorClock,
+	predicate func(*mutableStateBuilder) bool) (*mutableStateBuilder, error) {
+	if reqClock.ShardID == c.lastVectorClock.ShardID && reqClock.Clock <= c.lastVectorClock.Clock {
+		msBuilder, err := c.loadWorkflowExecution()
+		if err != nil {
+			return nil, err
+		}
+		if predicate == nil || predicate(msBuilder) {
+			return msBuilder, nil
+		}
+	}
+
+	deadline := time.Now().Add(c.shard.GetConfig().VectorClockConsistencyTimeout())
+	for {
+		c.clear()
+		msBuilder, err := c.loadWorkflowExecution()
+		if err != nil {
+			return nil, err
+		}
+
+		if predicate == nil || predicate(msBuilder) {
+			return msBuilder, nil
+		}
+
+		if time.Now().After(deadline) {
+			return nil, ErrLoadWithConsistencyTimeout
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(vectorClockConsistencyRetryInterval):
+		}
+	}
+}
+
 func (c *workflowExecutionContext) resetWorkflowExecution(resetBuilder *mutableStateBuilder) (*mutableStateBuilder,
 	error) {
 	snapshotRequest := resetBuilder.ResetSnapshot()
-	snapshotRequest.Condition = c.updateCondition
+
+	// Gate the CAS token the same way updateHelper does, so a reset during a rolling upgrade
+	// doesn't ship a token the rest of the fleet/store isn't expecting yet.
+	casMode := c.shard.GetConfig().DBRecordVersionCASMode(c.domainID)
+	casCondition, hasCondition, casVersion, hasVersion := dbRecordVersionCASFields(casMode, c.updateCondition,
+		c.nextDBRecordVersion())
+	if hasCondition {
+		snapshotRequest.Condition = casCondition
+	}
+	if hasVersion {
+		snapshotRequest.DBRecordVersion = casVersion
+	}
 
 	err := c.shard.ResetMutableState(snapshotRequest)
 	if err != nil {
@@ -121,6 +234,32 @@ func (c *workflowExecutionContext) resetWorkflowExecution(resetBuilder *mutableS
 	return c.loadWorkflowExecution()
 }
 
+// conflictResolveWorkflowExecution overwrites the current mutable state snapshot with
+// resetBuilder's (a replicated branch the NDC conflict resolver picked as current), gating the
+// CAS token the same way resetWorkflowExecution and updateHelper do so replication, reset, and
+// conflict-resolve all ship a consistent token during a rolling upgrade.
+func (c *workflowExecutionContext) conflictResolveWorkflowExecution(resetBuilder *mutableStateBuilder) (
+	*mutableStateBuilder, error) {
+	snapshotRequest := resetBuilder.ResetSnapshot()
+
+	casMode := c.shard.GetConfig().DBRecordVersionCASMode(c.domainID)
+	casCondition, hasCondition, casVersion, hasVersion := dbRecordVersionCASFields(casMode, c.updateCondition,
+		c.nextDBRecordVersion())
+	if hasCondition {
+		snapshotRequest.Condition = casCondition
+	}
+	if hasVersion {
+		snapshotRequest.DBRecordVersion = casVersion
+	}
+
+	if err := c.shard.ConflictResolveWorkflowExecution(snapshotRequest); err != nil {
+		return nil, err
+	}
+
+	c.clear()
+	return c.loadWorkflowExecution()
+}
+
 func (c *workflowExecutionContext) updateWorkflowExecutionWithContext(context []byte, transferTasks []persistence.Task,
 	timerTasks []persistence.Task, transactionID int64) error {
 	c.msBuilder.executionInfo.ExecutionContext = context
@@ -227,15 +366,26 @@ func (c *workflowExecutionContext) updateHelper(builder *historyBuilder, transfe
 	var finishExecutionTTL int32
 	if c.msBuilder.executionInfo.State == persistence.WorkflowStateCompleted {
 		// Workflow execution completed as part of this transaction.
-		// Also transactionally delete workflow execution representing
-		// current run for the execution using cassandra TTL
 		finishExecution = true
 		domainEntry, err := c.shard.GetDomainCache().GetDomainByID(c.msBuilder.executionInfo.DomainID)
 		if err != nil {
 			return err
 		}
-		// NOTE: domain retention is in days, so we need to do a conversion
-		finishExecutionTTL = domainEntry.GetConfig().Retention * secondsInDay
+
+		if c.shard.GetConfig().ArchivalHistoryEnabled(domainEntry.GetInfo().Name) {
+			// Hand history archival off to the archival queue processor instead of
+			// relying on the store's TTL, which only drops the row and never uploads anywhere.
+			transferTasks = append(transferTasks, &persistence.ArchivalTask{
+				DomainID:   c.domainID,
+				WorkflowID: *c.workflowExecution.WorkflowId,
+				RunID:      *c.workflowExecution.RunId,
+			})
+		} else {
+			// No archiver configured for this domain, fall back to the legacy behavior of
+			// transactionally deleting the current run's row using Cassandra TTL.
+			// NOTE: domain retention is in days, so we need to do a conversion
+			finishExecutionTTL = domainEntry.GetConfig().Retention * secondsInDay
+		}
 	}
 
 	var replicationTasks []persistence.Task
@@ -246,13 +396,15 @@ func (c *workflowExecutionContext) updateHelper(builder *historyBuilder, transfe
 
 	setTaskVersion(c.msBuilder.GetCurrentVersion(), transferTasks, timerTasks)
 
-	if err1 := c.updateWorkflowExecutionWithRetry(&persistence.UpdateWorkflowExecutionRequest{
+	casMode := c.shard.GetConfig().DBRecordVersionCASMode(c.msBuilder.executionInfo.DomainID)
+	nextDBRecordVersion := c.nextDBRecordVersion()
+
+	updateRequest := &persistence.UpdateWorkflowExecutionRequest{
 		ExecutionInfo:                 c.msBuilder.executionInfo,
 		ReplicationState:              c.msBuilder.replicationState,
 		TransferTasks:                 transferTasks,
 		ReplicationTasks:              replicationTasks,
 		TimerTasks:                    timerTasks,
-		Condition:                     c.updateCondition,
 		DeleteTimerTask:               c.deleteTimerTask,
 		UpsertActivityInfos:           updates.updateActivityInfos,
 		DeleteActivityInfos:           updates.deleteActivityInfos,
@@ -273,7 +425,20 @@ func (c *workflowExecutionContext) updateHelper(builder *historyBuilder, transfe
 		ContinueAsNew:                 continueAsNew,
 		FinishExecution:               finishExecution,
 		FinishedExecutionTTL:          finishExecutionTTL,
-	}); err1 != nil {
+	}
+
+	// Gate the CAS token used for conflict detection on the dynamic config rollout mode so
+	// that hosts running the old and new code can safely interoperate during a rolling upgrade.
+	casCondition, hasCondition, casVersion, hasVersion := dbRecordVersionCASFields(casMode, c.updateCondition,
+		nextDBRecordVersion)
+	if hasCondition {
+		updateRequest.Condition = casCondition
+	}
+	if hasVersion {
+		updateRequest.DBRecordVersion = casVersion
+	}
+
+	if err1 := c.executionManager.UpdateWorkflowExecution(updateRequest); err1 != nil {
 		switch err1.(type) {
 		case *persistence.ConditionFailedError:
 			return ErrConflict
@@ -284,8 +449,13 @@ func (c *workflowExecutionContext) updateHelper(builder *historyBuilder, transfe
 		return err1
 	}
 
-	// Update went through so update the condition for new updates
+	// Update went through so update the CAS tokens for new updates
 	c.updateCondition = c.msBuilder.GetNextEventID()
+	c.dbRecordVersion = nextDBRecordVersion
+	// Refresh the observed vector clock too, otherwise a subsequent loadWithConsistency call
+	// with a reqClock at or after this write would wrongly conclude its cached state might be
+	// stale and force an unnecessary persistence re-read.
+	c.lastVectorClock = c.shard.GetCurrentVectorClock()
 	c.msBuilder.executionInfo.LastUpdatedTimestamp = time.Now()
 
 	// for any change in the workflow, send a event
@@ -335,21 +505,129 @@ func (c *workflowExecutionContext) replicateContinueAsNewWorkflowExecution(newSt
 	return c.continueAsNewWorkflowExecutionHelper(nil, newStateBuilder, transferTasks, timerTasks, transactionID)
 }
 
+// continueAsNewWorkflowExecution persists newStateBuilder as the new run. When
+// requestEagerDispatch is true and the domain has eager dispatch enabled, the first workflow
+// task is synchronously recorded as started -- under requestIdentity, the identity of the
+// worker that is opting into eager dispatch -- and returned inline instead of being left for the
+// matching service to hand out on poll, cutting first-task latency for short-lived
+// continue-as-new chains. The returned response is nil whenever eager dispatch did not happen.
+// The start/continue-as-new API handlers that decide requestEagerDispatch/requestIdentity from
+// the incoming request and plumb the returned response back to the worker live in the history
+// handler, outside workflowExecutionContext.go and outside this change set.
 func (c *workflowExecutionContext) continueAsNewWorkflowExecution(context []byte, newStateBuilder *mutableStateBuilder,
-	transferTasks []persistence.Task, timerTasks []persistence.Task, transactionID int64) error {
+	transferTasks []persistence.Task, timerTasks []persistence.Task, transactionID int64,
+	requestEagerDispatch bool, requestIdentity string) (*h.RecordDecisionTaskStartedResponse, error) {
+
+	eagerResponse, transferTasks, err := c.maybeDispatchFirstDecisionTaskEagerly(newStateBuilder, transferTasks,
+		requestEagerDispatch, requestIdentity)
+	if err != nil {
+		return nil, err
+	}
 
 	err1 := c.continueAsNewWorkflowExecutionHelper(context, newStateBuilder, transferTasks, timerTasks, transactionID)
 	if err1 != nil {
-		return err1
+		return nil, err1
 	}
 
 	err2 := c.updateWorkflowExecutionWithContext(context, transferTasks, timerTasks, transactionID)
-
 	if err2 != nil {
 		// TODO: Delete new execution if update fails due to conflict or shard being lost
+		return nil, err2
+	}
+
+	return eagerResponse, nil
+}
+
+// maybeDispatchFirstDecisionTaskEagerly synthesizes a RecordDecisionTaskStartedResponse for the
+// new run's first workflow task when eager dispatch is requested and enabled for the domain,
+// and strips the corresponding decision transfer task out of transferTasks so it isn't also
+// handed out through the normal transfer queue. The started event/decisionInfo records
+// requestIdentity as the task's owner, just like a normal PollForDecisionTask response would
+// record the polling worker's identity, so that a subsequent RespondDecisionTaskCompleted from
+// that same worker CASes correctly.
+func (c *workflowExecutionContext) maybeDispatchFirstDecisionTaskEagerly(newStateBuilder *mutableStateBuilder,
+	transferTasks []persistence.Task, requestEagerDispatch bool, requestIdentity string) (
+	*h.RecordDecisionTaskStartedResponse, []persistence.Task, error) {
+	if !requestEagerDispatch {
+		return nil, transferTasks, nil
+	}
+
+	if requestIdentity == "" {
+		// Fall back to a synthesized identity so the started event never records an empty
+		// owner, even if the caller didn't have one to thread through.
+		requestIdentity = "eager-dispatch:" + common.GenerateRandomString()
+	}
+
+	domainEntry, err := c.shard.GetDomainCache().GetDomainByID(newStateBuilder.executionInfo.DomainID)
+	if err != nil {
+		return nil, transferTasks, err
+	}
+
+	if !c.shard.GetConfig().EnableEagerWorkflowTaskDispatch(domainEntry.GetInfo().Name) {
+		return nil, transferTasks, nil
+	}
+
+	taskTypes := make([]int, len(transferTasks))
+	for i, task := range transferTasks {
+		taskTypes[i] = task.GetType()
+	}
+	decisionTaskIndex := decisionTaskTransferIndex(taskTypes, persistence.TransferTaskTypeDecisionTask)
+	if decisionTaskIndex == -1 {
+		// The new run didn't schedule a workflow task (e.g. it completed synchronously), so
+		// there is nothing to dispatch eagerly.
+		return nil, transferTasks, nil
+	}
+
+	startedEvent, decisionInfo, err := newStateBuilder.AddDecisionTaskStartedEvent(
+		newStateBuilder.executionInfo.NextEventID-1,
+		common.GenerateRandomString(),
+		&workflow.PollForDecisionTaskRequest{
+			Domain:   common.StringPtr(domainEntry.GetInfo().Name),
+			Identity: common.StringPtr(requestIdentity),
+		},
+	)
+	if err != nil {
+		return nil, transferTasks, err
+	}
+
+	response := &h.RecordDecisionTaskStartedResponse{
+		WorkflowType:           newStateBuilder.getWorkflowType(),
+		PreviousStartedEventId: common.Int64Ptr(0),
+		ScheduledEventId:       common.Int64Ptr(decisionInfo.ScheduleID),
+		StartedEventId:         common.Int64Ptr(*startedEvent.EventId),
+		NextEventId:            common.Int64Ptr(newStateBuilder.GetNextEventID()),
+		Attempt:                common.Int64Ptr(int64(decisionInfo.Attempt)),
+		StickyExecutionEnabled: common.BoolPtr(false),
+		History:                &workflow.History{Events: newStateBuilder.hBuilder.history},
 	}
 
-	return err2
+	// This decision task is being handed to the caller inline, so drop it from the transfer
+	// task list to avoid the matching service also fanning it out to a poller.
+	remainingTasks := removeTransferTaskAt(transferTasks, decisionTaskIndex)
+
+	return response, remainingTasks, nil
+}
+
+// decisionTaskTransferIndex returns the index of the first occurrence of decisionTaskType in
+// taskTypes (the TaskType of each pending transfer task, in order), or -1 if none is scheduled.
+// Extracted as a pure function, decoupled from persistence.Task itself, so the search can be
+// unit tested without needing a real transfer task implementation.
+func decisionTaskTransferIndex(taskTypes []int, decisionTaskType int) int {
+	for i, t := range taskTypes {
+		if t == decisionTaskType {
+			return i
+		}
+	}
+	return -1
+}
+
+// removeTransferTaskAt returns a copy of tasks with the entry at index removed, or tasks
+// unchanged if index is out of range.
+func removeTransferTaskAt(tasks []persistence.Task, index int) []persistence.Task {
+	if index < 0 || index >= len(tasks) {
+		return tasks
+	}
+	return append(append([]persistence.Task{}, tasks[:index]...), tasks[index+1:]...)
 }
 
 func (c *workflowExecutionContext) continueAsNewWorkflowExecutionHelper(context []byte, newStateBuilder *mutableStateBuilder,
@@ -380,33 +658,81 @@ func (c *workflowExecutionContext) continueAsNewWorkflowExecutionHelper(context
 	})
 }
 
-func (c *workflowExecutionContext) getWorkflowExecutionWithRetry(
-	request *persistence.GetWorkflowExecutionRequest) (*persistence.GetWorkflowExecutionResponse, error) {
-	var response *persistence.GetWorkflowExecutionResponse
-	op := func() error {
-		var err error
-		response, err = c.executionManager.GetWorkflowExecution(request)
+func (c *workflowExecutionContext) clear() {
+	c.msBuilder = nil
+}
 
+// lock acquires the context's mutex and records who is holding it, so that a stuck caller (slow
+// persistence call, downstream RPC, cache eviction callback) shows up in the deadlock detector
+// instead of silently stalling the workflow.
+func (c *workflowExecutionContext) lock(ctx context.Context) error {
+	if err := c.locker.Lock(ctx); err != nil {
 		return err
 	}
 
-	err := backoff.Retry(op, persistenceOperationRetryPolicy, common.IsPersistenceTransientError)
-	if err != nil {
-		return nil, err
+	buf := make([]byte, 4096)
+	buf = buf[:runtime.Stack(buf, false)]
+
+	c.lockHolderMu.Lock()
+	c.lockHolder = &lockHolderInfo{
+		acquireTime: time.Now(),
+		stackTrace:  string(buf),
 	}
+	c.lockHolderMu.Unlock()
 
-	return response, nil
+	return nil
 }
 
-func (c *workflowExecutionContext) updateWorkflowExecutionWithRetry(
-	request *persistence.UpdateWorkflowExecutionRequest) error {
-	op := func() error {
-		return c.shard.UpdateWorkflowExecution(request)
+// unlock releases the context's mutex and clears the recorded holder.
+func (c *workflowExecutionContext) unlock() {
+	c.lockHolderMu.Lock()
+	c.lockHolder = nil
+	c.lockHolderMu.Unlock()
+
+	c.locker.Unlock()
+}
+
+// GetPingChecks implements Pingable. It reports whether the context's lock has been held for at
+// least the configured deadlock detection threshold, and by whom, without itself blocking on the
+// lock.
+func (c *workflowExecutionContext) GetPingChecks() []PingCheck {
+	return []PingCheck{
+		{
+			Name:    fmt.Sprintf("workflow-execution-context-lock-%v-%v", *c.workflowExecution.WorkflowId, *c.workflowExecution.RunId),
+			Timeout: c.shard.GetConfig().DeadlockDetectionThreshold(),
+			Ping: func() error {
+				c.lockHolderMu.Lock()
+				holder := c.lockHolder
+				c.lockHolderMu.Unlock()
+
+				threshold := c.shard.GetConfig().DeadlockDetectionThreshold()
+				return checkLockHolderStuck(holder, threshold, time.Now())
+			},
+		},
 	}
+}
 
-	return backoff.Retry(op, persistenceOperationRetryPolicy, common.IsPersistenceTransientError)
+// checkLockHolderStuck reports whether holder has held its lock for at least threshold, as of
+// now. It returns nil when there is no holder (the lock is free) or the hold time is still
+// within threshold, and a descriptive error only once the hold is actually unhealthy -- a nil
+// comparison alone would flag every actively-mutating context on every tick.
+func checkLockHolderStuck(holder *lockHolderInfo, threshold time.Duration, now time.Time) error {
+	if holder == nil {
+		return nil
+	}
+
+	if heldFor := now.Sub(holder.acquireTime); heldFor < threshold {
+		return nil
+	}
+
+	return fmt.Errorf("lock held since %v:\n%s", holder.acquireTime, holder.stackTrace)
 }
 
-func (c *workflowExecutionContext) clear() {
-	c.msBuilder = nil
+// nextDBRecordVersion returns the DBRecordVersion that should be persisted by the in-flight
+// update. DBRecordVersion is a monotonically increasing counter on the executions row that is
+// bumped on every mutable-state write, independent of whether history advances, so it can be
+// used as a CAS token for operations (heartbeats, buffered event flushes, replication acks)
+// that don't change NextEventID.
+func (c *workflowExecutionContext) nextDBRecordVersion() int64 {
+	return c.dbRecordVersion + 1
 }
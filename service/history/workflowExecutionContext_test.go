@@ -0,0 +1,81 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package history
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uber/cadence/common/persistence"
+)
+
+func TestDBRecordVersionCASFields(t *testing.T) {
+	const condition = int64(42)
+	const nextVersion = int64(7)
+
+	casCondition, hasCondition, casVersion, hasVersion := dbRecordVersionCASFields(dbRecordVersionCASModeOff,
+		condition, nextVersion)
+	require.True(t, hasCondition)
+	require.Equal(t, condition, casCondition)
+	require.False(t, hasVersion, "off mode must not send the new CAS token")
+
+	casCondition, hasCondition, casVersion, hasVersion = dbRecordVersionCASFields(dbRecordVersionCASModeDual,
+		condition, nextVersion)
+	require.True(t, hasCondition)
+	require.Equal(t, condition, casCondition)
+	require.True(t, hasVersion)
+	require.Equal(t, nextVersion, casVersion)
+
+	casCondition, hasCondition, casVersion, hasVersion = dbRecordVersionCASFields(dbRecordVersionCASModeV2,
+		condition, nextVersion)
+	require.False(t, hasCondition, "v2 mode must not send the legacy condition")
+	require.True(t, hasVersion)
+	require.Equal(t, nextVersion, casVersion)
+
+	// An unrecognized mode should behave like "off" rather than silently dropping CAS
+	// protection altogether.
+	casCondition, hasCondition, _, hasVersion = dbRecordVersionCASFields("unknown-mode", condition, nextVersion)
+	require.True(t, hasCondition)
+	require.Equal(t, condition, casCondition)
+	require.False(t, hasVersion)
+}
+
+func TestDecisionTaskTransferIndex(t *testing.T) {
+	const decisionTaskType = persistence.TransferTaskTypeDecisionTask
+	const otherType = decisionTaskType + 1
+
+	require.Equal(t, -1, decisionTaskTransferIndex(nil, decisionTaskType),
+		"no tasks scheduled means nothing to dispatch eagerly")
+	require.Equal(t, -1, decisionTaskTransferIndex([]int{otherType, otherType}, decisionTaskType))
+	require.Equal(t, 1, decisionTaskTransferIndex([]int{otherType, decisionTaskType, otherType}, decisionTaskType))
+}
+
+func TestRemoveTransferTaskAt(t *testing.T) {
+	tasks := []persistence.Task{nil, nil, nil}
+
+	remaining := removeTransferTaskAt(tasks, 1)
+	require.Len(t, remaining, 2)
+
+	// Out of range indexes (e.g. "no decision task found") leave the slice untouched.
+	require.Equal(t, tasks, removeTransferTaskAt(tasks, -1))
+	require.Equal(t, tasks, removeTransferTaskAt(tasks, len(tasks)))
+}